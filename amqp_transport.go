@@ -0,0 +1,28 @@
+package fluent
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+)
+
+// Publish sends msg to the default exchange with subject as the routing
+// key, using a lazily-created confirm-mode Publisher shared across calls.
+// It satisfies Transport's Publish method; BrokerBuilder does not implement
+// Subscribe, since a dynamically added Sink would need to be served before
+// the next reconnect and sink registration isn't synchronized for
+// post-Start use — the AMQP CLI path drives Sink/Exchange directly instead.
+func (b *BrokerBuilder) Publish(ctx context.Context, subject string, msg Message) error {
+	b.publisherOnce.Do(func() {
+		b.publisher = b.Publisher().Confirm()
+	})
+	return b.publisher.Publish(ctx, "", subject, amqp.Publishing{
+		Headers:       msg.Headers,
+		ContentType:   msg.ContentType,
+		CorrelationId: msg.CorrelationId,
+		ReplyTo:       msg.ReplyTo,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Body:          msg.Body,
+	})
+}
@@ -0,0 +1,165 @@
+package fluent
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/amqp"
+)
+
+// BrokerBuilder configures and starts a reconnecting AMQP connection. Create
+// one with Broker.
+type BrokerBuilder struct {
+	urls     []string
+	ctx      context.Context
+	logger   *log.Logger
+	interval time.Duration
+	timeout  time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	conn   *amqp.Connection
+	sinks  []*Sink
+
+	publisherOnce sync.Once
+	publisher     *Publisher
+
+	metrics *brokerMetrics
+}
+
+// Broker creates a builder that will dial the given URLs in order, falling
+// back to the next one on failure.
+func Broker(urls ...string) *BrokerBuilder {
+	return &BrokerBuilder{
+		urls:     urls,
+		ctx:      context.Background(),
+		logger:   log.New(os.Stderr, "[broker] ", log.LstdFlags),
+		interval: 5 * time.Second,
+		timeout:  30 * time.Second,
+	}
+}
+
+// Context sets the context that controls the broker lifetime; canceling it
+// stops reconnection and closes the connection.
+func (b *BrokerBuilder) Context(ctx context.Context) *BrokerBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// Logger sets the logger used for connection lifecycle messages.
+func (b *BrokerBuilder) Logger(logger *log.Logger) *BrokerBuilder {
+	b.logger = logger
+	return b
+}
+
+// Interval sets the delay between reconnection attempts.
+func (b *BrokerBuilder) Interval(interval time.Duration) *BrokerBuilder {
+	b.interval = interval
+	return b
+}
+
+// Timeout sets the per-attempt dial timeout.
+func (b *BrokerBuilder) Timeout(timeout time.Duration) *BrokerBuilder {
+	b.timeout = timeout
+	return b
+}
+
+// Metrics registers Prometheus collectors for connection state,
+// reconnects, message counts and handler latency, and instruments them
+// internally so callers only need to expose registerer on an HTTP handler.
+// role labels every series (e.g. "source", "forward") so that more than one
+// BrokerBuilder can share a single registerer.
+func (b *BrokerBuilder) Metrics(registerer prometheus.Registerer, role string) *BrokerBuilder {
+	b.metrics = newBrokerMetrics(registerer, role)
+	return b
+}
+
+// Sink declares a consumer-side configuration bound to queue (or an
+// auto-generated name when queue is empty).
+func (b *BrokerBuilder) Sink(queue string) *Sink {
+	sink := &Sink{broker: b, queue: queue}
+	b.sinks = append(b.sinks, sink)
+	return sink
+}
+
+// Start connects (with reconnection) in the background and begins serving
+// any sinks/exchanges already configured. It returns the same builder so
+// calls can be chained.
+func (b *BrokerBuilder) Start() *BrokerBuilder {
+	ctx, cancel := context.WithCancel(b.ctx)
+	b.ctx = ctx
+	b.cancel = cancel
+	b.wg.Add(1)
+	go b.run(ctx)
+	return b
+}
+
+func (b *BrokerBuilder) run(ctx context.Context) {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		conn, err := b.dial(ctx)
+		if err != nil {
+			b.logger.Println("connect failed:", err)
+			select {
+			case <-time.After(b.interval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		b.mu.Lock()
+		b.conn = conn
+		b.mu.Unlock()
+		if b.metrics != nil {
+			b.metrics.reconnects.Inc()
+			b.metrics.connectionState.Set(1)
+		}
+		for _, sink := range b.sinks {
+			sink.serve(ctx, conn)
+		}
+		closed := make(chan *amqp.Error, 1)
+		conn.NotifyClose(closed)
+		select {
+		case err := <-closed:
+			b.logger.Println("connection closed:", err)
+			if b.metrics != nil {
+				b.metrics.connectionState.Set(0)
+			}
+		case <-ctx.Done():
+			conn.Close()
+			if b.metrics != nil {
+				b.metrics.connectionState.Set(0)
+			}
+			return
+		}
+	}
+}
+
+func (b *BrokerBuilder) dial(ctx context.Context) (*amqp.Connection, error) {
+	var lastErr error
+	for _, url := range b.urls {
+		conn, err := amqp.DialConfig(url, amqp.Config{Dial: amqp.DefaultDial(b.timeout)})
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// WaitToFinish blocks until the broker's background goroutine has exited,
+// which happens once the context passed to Context is canceled and any
+// in-flight deliveries have been acknowledged.
+func (b *BrokerBuilder) WaitToFinish() {
+	b.wg.Wait()
+}
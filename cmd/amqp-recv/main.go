@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -8,12 +9,19 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/reddec/fluent-amqp"
 	"github.com/streadway/amqp"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
 	"text/template"
 	"time"
 )
@@ -29,11 +37,25 @@ var config struct {
 	Verify       string   `short:"s" long:"verify-public-cert" env:"BROKER_SIGN"        description:"Path to public cert to verify"`
 	Queue        string   `short:"Q" long:"queue"              env:"BROKER_QUEUE"       description:"Queue name or empty for autogenerated"`
 	Lazy         bool     `short:"l" long:"lazy"               env:"BROKER_LAZY"        description:"Make queue lazy (prefer keep data on disk)"`
-	OutType      string   `short:"o" long:"output"             env:"BROKER_OUTPUT"      description:"Output type" choice:"body" choice:"dump" choice:"json" choice:"template" default:"body"`
+	OutType      string   `short:"o" long:"output"             env:"BROKER_OUTPUT"      description:"Output type" choice:"body" choice:"dump" choice:"json" choice:"template" choice:"forward" choice:"exec-reply" default:"body"`
+	Limit        int      `short:"n" long:"limit"              env:"BROKER_LIMIT"       description:"Stop after N handled deliveries (0 - unlimited)"`
+	Prefetch     int      `short:"p" long:"prefetch"           env:"BROKER_PREFETCH"    description:"Max number of unacknowledged deliveries in flight (0 - unlimited)"`
+	BindingKeys  []string `short:"b" long:"binding-key"        env:"BROKER_BINDING_KEYS" description:"Additional routing key to bind the queue with (repeatable)"`
+	Transport    string   `long:"transport"                    env:"BROKER_TRANSPORT"    description:"Messaging backend" choice:"amqp" choice:"nats" default:"amqp"`
+	Subject      string   `long:"subject"                      env:"BROKER_SUBJECT"      description:"Routing key (amqp) or subject (nats); falls back to the positional routing-key"`
 	Args         struct {
 		RoutingKey string `positional-arg-name:"routing-key" env:"BROKER_ROUTING_KEY" description:"Routing key"`
 	} `positional-args:"yes"`
 
+	ForwardURLs     []string `long:"forward-url"      env:"BROKER_FORWARD_URL"      description:"Destination broker url(s), required when --output=forward"`
+	ForwardExchange string   `long:"forward-exchange" env:"BROKER_FORWARD_EXCHANGE" description:"Destination exchange name (empty - default exchange)"`
+	ForwardKind     string   `long:"forward-kind"     env:"BROKER_FORWARD_KIND"     description:"Destination exchange kind" choice:"direct" choice:"topic" choice:"fanout" default:"direct"`
+	ForwardKey      string   `long:"forward-key"      env:"BROKER_FORWARD_KEY"      description:"Go template evaluated over the delivery to override the destination routing key (default: keep the source routing key)"`
+
+	Exec string `long:"exec" env:"BROKER_EXEC" description:"Command (run via sh -c) piped the delivery body on stdin, required when --output=exec-reply"`
+
+	MetricsListen string `long:"metrics-listen" env:"BROKER_METRICS_LISTEN" description:"Address to expose Prometheus /metrics and /healthz on (empty - disabled)"`
+
 	Interval time.Duration `short:"R" long:"reconnect-interval" env:"BROKER_RECONNECT_INTERVAL" description:"Reconnect timeout" default:"5s"`
 	Timeout  time.Duration `short:"T" long:"timeout" env:"BROKER_CONNECT_TIMEOUT" description:"Connect timeout" default:"30s"`
 	Quiet    bool          `short:"q" long:"quiet" env:"BROKER_QUIET" description:"Suppress all log messages"`
@@ -44,16 +66,39 @@ var logOutput io.Writer = os.Stderr
 
 type dumpHandler struct{}
 
-func (dh *dumpHandler) Handle(ctx context.Context, msg amqp.Delivery) {
+func (dh *dumpHandler) Handle(ctx context.Context, msg fluent.Delivery) {
 	spew.Dump(msg)
 }
 
+// deliveryEnvelope is the transport-agnostic shape used to render a
+// delivery as JSON; it mirrors fluent.Delivery rather than any one
+// transport's native message so the output is stable across --transport.
+type deliveryEnvelope struct {
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	ContentType   string                 `json:"content_type,omitempty"`
+	CorrelationId string                 `json:"correlation_id,omitempty"`
+	ReplyTo       string                 `json:"reply_to,omitempty"`
+	MessageId     string                 `json:"message_id,omitempty"`
+	RoutingKey    string                 `json:"routing_key,omitempty"`
+	Timestamp     time.Time              `json:"timestamp,omitempty"`
+	Body          []byte                 `json:"body"`
+}
+
 type jsonHandler struct{}
 
-func (dh *jsonHandler) Handle(ctx context.Context, msg amqp.Delivery) {
+func (dh *jsonHandler) Handle(ctx context.Context, msg fluent.Delivery) {
 	dec := json.NewEncoder(os.Stdout)
 	dec.SetIndent("", "  ")
-	err := dec.Encode(&msg)
+	err := dec.Encode(&deliveryEnvelope{
+		Headers:       msg.Headers(),
+		ContentType:   msg.ContentType(),
+		CorrelationId: msg.CorrelationId(),
+		ReplyTo:       msg.ReplyTo(),
+		MessageId:     msg.MessageId(),
+		RoutingKey:    msg.RoutingKey(),
+		Timestamp:     msg.Timestamp(),
+		Body:          msg.Body(),
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -61,8 +106,8 @@ func (dh *jsonHandler) Handle(ctx context.Context, msg amqp.Delivery) {
 
 type plainHandler struct{}
 
-func (dh *plainHandler) Handle(ctx context.Context, msg amqp.Delivery) {
-	_, err := os.Stdout.Write(msg.Body)
+func (dh *plainHandler) Handle(ctx context.Context, msg fluent.Delivery) {
+	_, err := os.Stdout.Write(msg.Body())
 	if err != nil {
 		panic(err)
 	}
@@ -72,56 +117,290 @@ type templateHandler struct {
 	t *template.Template
 }
 
-func (dh *templateHandler) Handle(ctx context.Context, msg amqp.Delivery) {
+func (dh *templateHandler) Handle(ctx context.Context, msg fluent.Delivery) {
 	err := dh.t.Execute(os.Stdout, msg)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func run() error {
-	gctx, cancel := context.WithCancel(context.Background())
-	ctx := fluent.SignalContext(gctx)
-	broker := fluent.Broker(config.URLs...).Context(ctx).Logger(log.New(logOutput, "[broker] ", log.LstdFlags)).Interval(config.Interval).Timeout(config.Timeout).Start()
-	defer broker.WaitToFinish()
-	defer cancel()
-	log.Println("preparing sink")
-	publisherCfg := broker.Sink(config.Queue)
-	if config.Verify != "" {
-		log.Println("preparing validator")
-		publisherCfg = publisherCfg.Validate(config.Verify)
+// forwardHandler republishes every delivery to another broker, acking the
+// source message only once the destination publish is confirmed.
+type forwardHandler struct {
+	publisher   *fluent.Publisher
+	exchange    string
+	keyTemplate *template.Template
+}
+
+func (fh *forwardHandler) Handle(ctx context.Context, msg fluent.Delivery) {
+	key := msg.RoutingKey()
+	if fh.keyTemplate != nil {
+		var buf bytes.Buffer
+		if err := fh.keyTemplate.Execute(&buf, msg); err != nil {
+			log.Println("forward: failed to render routing key template:", err)
+			_ = msg.Nack(true)
+			return
+		}
+		key = buf.String()
 	}
-	if config.Lazy {
-		publisherCfg = publisherCfg.Lazy()
+	err := fh.publisher.Publish(ctx, fh.exchange, key, amqp.Publishing{
+		Headers:       msg.Headers(),
+		ContentType:   msg.ContentType(),
+		CorrelationId: msg.CorrelationId(),
+		ReplyTo:       msg.ReplyTo(),
+		MessageId:     msg.MessageId(),
+		Timestamp:     msg.Timestamp(),
+		Body:          msg.Body(),
+	})
+	if err != nil {
+		log.Println("forward: publish failed:", err)
+		_ = msg.Nack(true)
+		return
 	}
+	_ = msg.Ack()
+}
 
-	var handler fluent.SimpleHandler
+// execReplyHandler runs a command per delivery and publishes its stdout as
+// the RPC reply, turning the receiver into a lightweight AMQP RPC server.
+// Like forwardHandler, it relies on Publisher's confirm-mode handling to
+// know the reply actually reached the broker.
+type execReplyHandler struct {
+	publisher *fluent.Publisher
+	command   string
+}
+
+func (eh *execReplyHandler) Handle(ctx context.Context, msg fluent.Delivery) {
+	replyTo := msg.ReplyTo()
+	if replyTo == "" {
+		return
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", eh.command)
+	cmd.Stdin = bytes.NewReader(msg.Body())
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	reply := amqp.Publishing{
+		CorrelationId: msg.CorrelationId(),
+		Body:          stdout.Bytes(),
+	}
+	if runErr != nil {
+		log.Println("exec-reply: command failed:", runErr, stderr.String())
+		reply.Headers = amqp.Table{"error": runErr.Error()}
+	}
+	if err := eh.publisher.Publish(ctx, "", replyTo, reply); err != nil {
+		log.Println("exec-reply: failed to publish reply:", err)
+	}
+}
+
+// buildSimpleHandler constructs the handlers shared by every transport
+// (dump/json/body/template); forward and exec-reply stay AMQP-only since
+// they need a confirm-aware Publisher.
+func buildSimpleHandler() (fluent.SimpleHandler, error) {
 	switch config.OutType {
 	case "dump":
-		handler = &dumpHandler{}
+		return &dumpHandler{}, nil
 	case "json":
-		handler = &jsonHandler{}
+		return &jsonHandler{}, nil
 	case "body", "plain":
-		handler = &plainHandler{}
+		return &plainHandler{}, nil
 	case "template":
 		log.Println("reading template from STDIN")
 		data, err := ioutil.ReadAll(os.Stdin)
 		if err != nil && err != io.EOF {
-			return err
+			return nil, err
 		}
 		funcs := sprig.TxtFuncMap()
 		funcs["asText"] = func(data []byte) string { return string(data) }
 		t, err := template.New("").Funcs(funcs).Parse(string(data))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		handler = &templateHandler{t}
+		return &templateHandler{t}, nil
 	default:
-		panic("unknown output format")
+		return nil, errors.Errorf("--output=%s requires --transport=amqp", config.OutType)
+	}
+}
+
+func run() error {
+	gctx, cancel := context.WithCancel(context.Background())
+	ctx := fluent.SignalContext(gctx)
+	defer cancel()
+
+	subject := config.Subject
+	if subject == "" {
+		subject = config.Args.RoutingKey
+	}
+
+	var registry *prometheus.Registry
+	if config.MetricsListen != "" {
+		registry = prometheus.NewRegistry()
+		if err := startMetricsServer(ctx, config.MetricsListen, registry); err != nil {
+			return err
+		}
+	}
+
+	if config.Transport == "nats" {
+		// fluent_amqp_* metrics come from BrokerBuilder; NATSTransport has
+		// no metrics hook, so /metrics serves an empty registry here.
+		return runNATS(ctx, cancel, subject)
+	}
+	return runAMQP(ctx, cancel, subject, registry)
+}
+
+// startMetricsServer exposes registry on /metrics and a trivial /healthz
+// (always 200 once the process is up, regardless of broker connection
+// state) on addr, shutting the listener down once ctx is canceled.
+func startMetricsServer(ctx context.Context, addr string, registry *prometheus.Registry) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listen for metrics")
 	}
-	handlerFunc := func(ctx context.Context, msg amqp.Delivery) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Println("metrics server failed:", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	log.Println("metrics listening on", addr)
+	return nil
+}
+
+func runNATS(ctx context.Context, cancel context.CancelFunc, subject string) error {
+	if subject == "" {
+		return errors.New("--subject is required with --transport=nats")
+	}
+	// These are all AMQP-specific (signature verification, queue/consumer
+	// tuning): NATSTransport has no equivalent, so silently accepting them
+	// would make an operator believe a control (signature verification in
+	// particular) is enforced when it isn't.
+	if config.Verify != "" {
+		return errors.New("--verify-public-cert requires --transport=amqp")
+	}
+	if config.Lazy {
+		return errors.New("--lazy requires --transport=amqp")
+	}
+	if config.Prefetch > 0 {
+		return errors.New("--prefetch requires --transport=amqp")
+	}
+	if len(config.BindingKeys) > 0 {
+		return errors.New("--binding-key requires --transport=amqp")
+	}
+	handler, err := buildSimpleHandler()
+	if err != nil {
+		return err
+	}
+	transport := &fluent.NATSTransport{URL: strings.Join(config.URLs, ",")}
+	if err := transport.Connect(); err != nil {
+		return err
+	}
+	var delivered int64
+	err = transport.Subscribe(ctx, subject, func(msg fluent.Delivery) {
 		handler.Handle(ctx, msg)
+		if config.Limit > 0 && atomic.AddInt64(&delivered, 1) >= int64(config.Limit) {
+			cancel()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("reader prepared")
+	log.Println("waiting for messages...")
+	<-ctx.Done()
+	return nil
+}
+
+func runAMQP(ctx context.Context, cancel context.CancelFunc, routingKey string, registry *prometheus.Registry) error {
+	broker := fluent.Broker(config.URLs...).Context(ctx).Logger(log.New(logOutput, "[broker] ", log.LstdFlags)).Interval(config.Interval).Timeout(config.Timeout)
+	if registry != nil {
+		broker = broker.Metrics(registry, "source")
+	}
+	broker = broker.Start()
+	// cancel must run before WaitToFinish blocks on it, or any of the early
+	// returns below (before ctx would otherwise ever be canceled) hangs
+	// forever waiting on a reconnect loop nothing ever stops. cancel is
+	// idempotent, so calling it again from forwardBroker's own cleanup below
+	// is harmless - each defer is self-sufficient regardless of unwind order.
+	defer func() {
 		cancel()
+		broker.WaitToFinish()
+	}()
+	log.Println("preparing sink")
+	publisherCfg := broker.Sink(config.Queue)
+	if config.Verify != "" {
+		log.Println("preparing validator")
+		var err error
+		publisherCfg, err = publisherCfg.Validate(config.Verify)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Lazy {
+		publisherCfg = publisherCfg.Lazy()
+	}
+	if config.Prefetch > 0 {
+		publisherCfg = publisherCfg.Prefetch(config.Prefetch)
+	}
+
+	var handler fluent.SimpleHandler
+	switch config.OutType {
+	case "forward":
+		if len(config.ForwardURLs) == 0 {
+			return errors.New("--forward-url is required for --output=forward")
+		}
+		log.Println("connecting to forward destination")
+		forwardBroker := fluent.Broker(config.ForwardURLs...).Context(ctx).Logger(log.New(logOutput, "[forward] ", log.LstdFlags)).Interval(config.Interval).Timeout(config.Timeout)
+		if registry != nil {
+			forwardBroker = forwardBroker.Metrics(registry, "forward")
+		}
+		forwardBroker = forwardBroker.Start()
+		defer func() {
+			cancel()
+			forwardBroker.WaitToFinish()
+		}()
+		publisher := forwardBroker.Publisher().Confirm()
+		if config.ForwardExchange != "" {
+			publisher = publisher.DeclareExchange(config.ForwardExchange, config.ForwardKind)
+		}
+		var keyTemplate *template.Template
+		if config.ForwardKey != "" {
+			t, err := template.New("").Funcs(sprig.TxtFuncMap()).Parse(config.ForwardKey)
+			if err != nil {
+				return err
+			}
+			keyTemplate = t
+		}
+		handler = &forwardHandler{publisher: publisher, exchange: config.ForwardExchange, keyTemplate: keyTemplate}
+		publisherCfg = publisherCfg.ManualAck()
+	case "exec-reply":
+		if config.Exec == "" {
+			return errors.New("--exec is required for --output=exec-reply")
+		}
+		handler = &execReplyHandler{publisher: broker.Publisher().Confirm(), command: config.Exec}
+	default:
+		h, err := buildSimpleHandler()
+		if err != nil {
+			return err
+		}
+		handler = h
+	}
+	var delivered int64
+	handlerFunc := func(ctx context.Context, msg fluent.Delivery) {
+		handler.Handle(ctx, msg)
+		if config.Limit > 0 && atomic.AddInt64(&delivered, 1) >= int64(config.Limit) {
+			cancel()
+		}
 	}
 	var exc *fluent.Exchange
 	if config.Exchange != "" {
@@ -136,8 +415,11 @@ func run() error {
 			return errors.Errorf("unknown exchange type %v", config.ExchangeType)
 		}
 
-		if config.Args.RoutingKey != "" {
-			exc = exc.Key(config.Args.RoutingKey)
+		if routingKey != "" {
+			exc = exc.Key(routingKey)
+		}
+		for _, key := range config.BindingKeys {
+			exc = exc.Key(key)
 		}
 
 		exc.HandlerFunc(handlerFunc)
@@ -0,0 +1,46 @@
+package fluent
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Delivery is a transport-agnostic view over an inbound message. Consumers
+// (Sink for AMQP, NATSTransport for NATS) adapt their native message type
+// to it before handing it to a handler.
+type Delivery interface {
+	Body() []byte
+	Headers() map[string]interface{}
+	ContentType() string
+	CorrelationId() string
+	ReplyTo() string
+	MessageId() string
+	RoutingKey() string
+	Timestamp() time.Time
+	// Ack confirms the message was handled successfully.
+	Ack() error
+	// Nack reports the message was not handled; requeue asks the broker to
+	// redeliver it when the transport supports that.
+	Nack(requeue bool) error
+}
+
+// amqpDelivery adapts amqp.Delivery to Delivery.
+type amqpDelivery struct {
+	raw amqp.Delivery
+}
+
+func (d amqpDelivery) Body() []byte                    { return d.raw.Body }
+func (d amqpDelivery) Headers() map[string]interface{} { return d.raw.Headers }
+func (d amqpDelivery) ContentType() string             { return d.raw.ContentType }
+func (d amqpDelivery) CorrelationId() string           { return d.raw.CorrelationId }
+func (d amqpDelivery) ReplyTo() string                 { return d.raw.ReplyTo }
+func (d amqpDelivery) MessageId() string               { return d.raw.MessageId }
+func (d amqpDelivery) RoutingKey() string              { return d.raw.RoutingKey }
+func (d amqpDelivery) Timestamp() time.Time            { return d.raw.Timestamp }
+func (d amqpDelivery) Ack() error                      { return d.raw.Ack(false) }
+func (d amqpDelivery) Nack(requeue bool) error         { return d.raw.Nack(false, requeue) }
+
+// Raw returns the underlying amqp.Delivery for callers that need AMQP
+// specifics a generic Delivery can't express.
+func (d amqpDelivery) Raw() amqp.Delivery { return d.raw }
@@ -0,0 +1,4 @@
+// Package fluent provides a fluent, builder-style API over streadway/amqp
+// for declaring exchanges/queues, binding routing keys and consuming
+// deliveries with automatic reconnection.
+package fluent
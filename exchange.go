@@ -0,0 +1,25 @@
+package fluent
+
+// Exchange configures the binding of a sink's queue to an exchange. Create
+// one with Sink.Topic, Sink.Direct or Sink.Fanout.
+type Exchange struct {
+	sink    *Sink
+	name    string
+	kind    string
+	keys    []string
+	handler SimpleHandler
+}
+
+// Key adds a routing key to bind the queue with. It may be called multiple
+// times to subscribe to several routing keys on the same exchange; on
+// fanout exchanges the key is ignored by the broker.
+func (e *Exchange) Key(key string) *Exchange {
+	e.keys = append(e.keys, key)
+	return e
+}
+
+// HandlerFunc registers handler to be invoked for every delivery routed to
+// the bound queue.
+func (e *Exchange) HandlerFunc(handler SimpleHandlerFunc) {
+	e.handler = handler
+}
@@ -0,0 +1,21 @@
+package fluent
+
+import (
+	"context"
+)
+
+// SimpleHandler processes a single delivery, transport-independent. Call
+// ManualAck on the Sink when ack/nack needs to happen asynchronously (e.g.
+// only once a downstream publish is confirmed); by default the consume
+// loop acks automatically once Handle returns.
+type SimpleHandler interface {
+	Handle(ctx context.Context, msg Delivery)
+}
+
+// SimpleHandlerFunc adapts a plain function to the SimpleHandler interface.
+type SimpleHandlerFunc func(ctx context.Context, msg Delivery)
+
+// Handle invokes the function.
+func (f SimpleHandlerFunc) Handle(ctx context.Context, msg Delivery) {
+	f(ctx, msg)
+}
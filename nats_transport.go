@@ -0,0 +1,89 @@
+package fluent
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSTransport implements Transport on top of a plain (non-JetStream) NATS
+// connection. Core NATS has no redelivery/ack concept, so Delivery.Ack and
+// Delivery.Nack are no-ops that always succeed.
+type NATSTransport struct {
+	URL  string
+	Opts []nats.Option
+
+	conn *nats.Conn
+}
+
+// Connect dials the configured NATS server. It must be called before
+// Subscribe or Publish.
+func (t *NATSTransport) Connect() error {
+	conn, err := nats.Connect(t.URL, t.Opts...)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+// Subscribe implements Transport.
+func (t *NATSTransport) Subscribe(ctx context.Context, subject string, handler func(Delivery)) error {
+	sub, err := t.conn.Subscribe(subject, func(msg *nats.Msg) {
+		handler(natsDelivery{msg: msg})
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = sub.Unsubscribe()
+	}()
+	return nil
+}
+
+// Publish implements Transport.
+func (t *NATSTransport) Publish(ctx context.Context, subject string, msg Message) error {
+	headers := make(nats.Header, len(msg.Headers))
+	for key, value := range msg.Headers {
+		headers.Set(key, headerString(value))
+	}
+	return t.conn.PublishMsg(&nats.Msg{
+		Subject: subject,
+		Reply:   msg.ReplyTo,
+		Header:  headers,
+		Data:    msg.Body,
+	})
+}
+
+func headerString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return ""
+}
+
+// natsDelivery adapts *nats.Msg to Delivery.
+type natsDelivery struct {
+	msg *nats.Msg
+}
+
+func (d natsDelivery) Body() []byte { return d.msg.Data }
+func (d natsDelivery) Headers() map[string]interface{} {
+	out := make(map[string]interface{}, len(d.msg.Header))
+	for key, values := range d.msg.Header {
+		if len(values) > 0 {
+			out[key] = values[0]
+		}
+	}
+	return out
+}
+func (d natsDelivery) ContentType() string     { return d.msg.Header.Get("Content-Type") }
+func (d natsDelivery) CorrelationId() string   { return d.msg.Header.Get("Correlation-Id") }
+func (d natsDelivery) ReplyTo() string         { return d.msg.Reply }
+func (d natsDelivery) MessageId() string       { return d.msg.Header.Get("Message-Id") }
+func (d natsDelivery) RoutingKey() string      { return d.msg.Subject }
+func (d natsDelivery) Timestamp() time.Time    { return time.Time{} }
+func (d natsDelivery) Ack() error              { return nil }
+func (d natsDelivery) Nack(requeue bool) error { return nil }
@@ -0,0 +1,180 @@
+package fluent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// Publisher publishes messages on a broker's connection. Create one with
+// BrokerBuilder.Publisher.
+type Publisher struct {
+	broker       *BrokerBuilder
+	confirm      bool
+	exchangeName string
+	exchangeKind string
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	nextTag uint64
+	pending map[uint64]chan amqp.Confirmation
+}
+
+// Publisher returns a publisher bound to this broker's (reconnecting)
+// connection.
+func (b *BrokerBuilder) Publisher() *Publisher {
+	return &Publisher{broker: b}
+}
+
+// Confirm puts the underlying channel into publisher-confirm mode so that
+// Publish waits for the broker to acknowledge each message before
+// returning.
+func (p *Publisher) Confirm() *Publisher {
+	p.confirm = true
+	return p
+}
+
+// DeclareExchange makes Publish declare the given exchange (of kind
+// "direct", "topic" or "fanout") before the first publish.
+func (p *Publisher) DeclareExchange(name, kind string) *Publisher {
+	p.exchangeName = name
+	p.exchangeKind = kind
+	return p
+}
+
+func (p *Publisher) channel() (*amqp.Channel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.channelLocked()
+}
+
+// channelLocked is channel's implementation; callers must hold p.mu for its
+// duration. publish keeps the lock held across this call and its own
+// tag-register/ch.Publish sequence below, so a concurrent reconnect can't
+// swap p.ch/p.conn (and reset p.nextTag/p.pending) out from under a publish
+// already in flight on the channel and tag it fetched.
+func (p *Publisher) channelLocked() (*amqp.Channel, error) {
+	p.broker.mu.Lock()
+	conn := p.broker.conn
+	p.broker.mu.Unlock()
+	if conn == nil {
+		return nil, errors.New("not connected yet")
+	}
+	// The broker reconnects transparently and hands out a new *amqp.Connection
+	// each time; compare against the one our cached channel belongs to so a
+	// reconnect gets a fresh channel (and confirm listener) instead of reusing
+	// one tied to a connection that's already gone.
+	if p.ch != nil && p.conn == conn {
+		return p.ch, nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, errors.Wrap(err, "open channel")
+	}
+	if p.confirm {
+		if err := ch.Confirm(false); err != nil {
+			return nil, errors.Wrap(err, "switch to confirm mode")
+		}
+		p.nextTag = 0
+		p.pending = make(map[uint64]chan amqp.Confirmation)
+		confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 64))
+		go p.dispatchConfirms(confirms)
+	}
+	if p.exchangeName != "" {
+		if err := ch.ExchangeDeclare(p.exchangeName, p.exchangeKind, true, false, false, false, nil); err != nil {
+			return nil, errors.Wrap(err, "declare exchange")
+		}
+	}
+	p.conn = conn
+	p.ch = ch
+	return ch, nil
+}
+
+// dispatchConfirms is the single long-lived reader of the channel's
+// NotifyPublish stream; it routes each confirmation back to the pending
+// Publish call with the matching delivery tag. Registering one listener up
+// front (rather than one per Publish call, which the AMQP library never
+// removes) is required: confirms are delivered serially from the
+// connection's frame-reader goroutine, so an abandoned listener with a full
+// buffer would block every future confirmation, including this one's.
+func (p *Publisher) dispatchConfirms(confirms <-chan amqp.Confirmation) {
+	for confirmation := range confirms {
+		p.mu.Lock()
+		waiter, ok := p.pending[confirmation.DeliveryTag]
+		if ok {
+			delete(p.pending, confirmation.DeliveryTag)
+		}
+		p.mu.Unlock()
+		if ok {
+			waiter <- confirmation
+		}
+	}
+}
+
+// Publish sends msg to exchange with routingKey. When Confirm was
+// requested it blocks until the broker acknowledges (acks or nacks) the
+// message, or ctx is done.
+func (p *Publisher) Publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	err := p.publish(ctx, exchange, routingKey, msg)
+	if err != nil && p.broker.metrics != nil {
+		p.broker.metrics.publishErrors.Inc()
+	}
+	return err
+}
+
+func (p *Publisher) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	ch, err := p.channelLocked()
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	if !p.confirm {
+		p.mu.Unlock()
+		if err := ch.Publish(exchange, routingKey, false, false, msg); err != nil {
+			return errors.Wrap(err, "publish")
+		}
+		return nil
+	}
+
+	// The library only bumps its own delivery-tag sequence after a publish
+	// is actually written to the wire (amqp.Channel.Publish calls
+	// confirms.Publish() only past a successful send), so our mirrored
+	// counter must roll back on error too, or every publish after the first
+	// failure would wait on a tag the broker never assigns. Fetching the
+	// channel, registering the waiter and calling ch.Publish all under the
+	// same p.mu hold (not released between channelLocked and here) keeps our
+	// tag in lockstep with the broker's regardless of how many goroutines
+	// call Publish concurrently - a reconnect can't swap p.ch/p.conn and
+	// reset p.nextTag/p.pending out from under a publish already using them.
+	p.nextTag++
+	tag := p.nextTag
+	waiter := make(chan amqp.Confirmation, 1)
+	p.pending[tag] = waiter
+	err = ch.Publish(exchange, routingKey, false, false, msg)
+	if err != nil {
+		delete(p.pending, tag)
+		p.nextTag--
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "publish")
+	}
+
+	select {
+	case confirmation := <-waiter:
+		if !confirmation.Ack {
+			return errors.New("broker nacked the message")
+		}
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, tag)
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
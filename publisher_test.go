@@ -0,0 +1,45 @@
+package fluent
+
+import (
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+// TestPublisherDispatchConfirmsRoutesByDeliveryTag guards against the
+// deadlock where a single shared NotifyPublish listener delivered every
+// confirmation to whichever Publish call happened to be listening first:
+// dispatchConfirms must route each confirmation to the waiter registered
+// under its own delivery tag, in any arrival order.
+func TestPublisherDispatchConfirmsRoutesByDeliveryTag(t *testing.T) {
+	p := &Publisher{pending: make(map[uint64]chan amqp.Confirmation)}
+	waiter1 := make(chan amqp.Confirmation, 1)
+	waiter2 := make(chan amqp.Confirmation, 1)
+	p.pending[1] = waiter1
+	p.pending[2] = waiter2
+
+	confirms := make(chan amqp.Confirmation, 2)
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	close(confirms)
+
+	p.dispatchConfirms(confirms)
+
+	select {
+	case c := <-waiter2:
+		if !c.Ack {
+			t.Fatalf("waiter2: expected an ack, got %+v", c)
+		}
+	default:
+		t.Fatal("waiter2 never received its confirmation")
+	}
+
+	select {
+	case c := <-waiter1:
+		if c.Ack {
+			t.Fatalf("waiter1: expected a nack, got %+v", c)
+		}
+	default:
+		t.Fatal("waiter1 never received its confirmation")
+	}
+}
@@ -0,0 +1,70 @@
+package fluent
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// signatureHeader carries a base64-encoded RSA-SHA256 signature over the
+// delivery body, set by a trusted publisher and checked by Sink.Validate.
+const signatureHeader = "x-signature"
+
+// parseVerificationKeys reads one or more PEM certificates from data and
+// returns the public key of each.
+func parseVerificationKeys(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse certificate")
+		}
+		keys = append(keys, cert.PublicKey)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return keys, nil
+}
+
+// verifySignature reports whether msg carries an x-signature header that
+// verifies, under any of keys, as an RSA-SHA256 signature over the body.
+func verifySignature(keys []crypto.PublicKey, msg amqp.Delivery) bool {
+	raw, ok := msg.Headers[signatureHeader]
+	if !ok {
+		return false
+	}
+	encoded, ok := raw.(string)
+	if !ok {
+		return false
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(msg.Body)
+	for _, key := range keys {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) == nil {
+			return true
+		}
+	}
+	return false
+}
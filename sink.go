@@ -0,0 +1,184 @@
+package fluent
+
+import (
+	"context"
+	"crypto"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// Sink configures how a queue is declared and consumed. Create one with
+// BrokerBuilder.Sink.
+type Sink struct {
+	broker     *BrokerBuilder
+	queue      string
+	lazy       bool
+	prefetch   int
+	manualAck  bool
+	verifyKeys []crypto.PublicKey
+	exchange   *Exchange
+	handler    SimpleHandler
+}
+
+// Validate requires that every delivery carries an x-signature header
+// verifiable against one of the public certificates stored at path;
+// unverifiable deliveries are nacked without being passed to the handler.
+func (s *Sink) Validate(path string) (*Sink, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s, errors.Wrap(err, "read verification cert")
+	}
+	keys, err := parseVerificationKeys(data)
+	if err != nil {
+		return s, err
+	}
+	s.verifyKeys = keys
+	return s, nil
+}
+
+// Lazy marks the queue as lazy, preferring to keep messages on disk.
+func (s *Sink) Lazy() *Sink {
+	s.lazy = true
+	return s
+}
+
+// Prefetch caps the number of unacknowledged deliveries the broker will
+// hand to this consumer at once, applied via channel.Qos before consuming.
+func (s *Sink) Prefetch(count int) *Sink {
+	s.prefetch = count
+	return s
+}
+
+// ManualAck disables the default "ack once Handle returns" behaviour;
+// the handler becomes responsible for calling msg.Ack/msg.Nack itself,
+// e.g. only after a downstream publish has been confirmed.
+func (s *Sink) ManualAck() *Sink {
+	s.manualAck = true
+	return s
+}
+
+// Topic declares (or reuses) a topic exchange bound to this sink's queue.
+func (s *Sink) Topic(name string) *Exchange {
+	return s.exchangeOf(name, "topic")
+}
+
+// Direct declares (or reuses) a direct exchange bound to this sink's queue.
+func (s *Sink) Direct(name string) *Exchange {
+	return s.exchangeOf(name, "direct")
+}
+
+// Fanout declares (or reuses) a fanout exchange bound to this sink's queue.
+func (s *Sink) Fanout(name string) *Exchange {
+	return s.exchangeOf(name, "fanout")
+}
+
+func (s *Sink) exchangeOf(name, kind string) *Exchange {
+	s.exchange = &Exchange{sink: s, name: name, kind: kind}
+	return s.exchange
+}
+
+// HandlerFunc registers handler to be invoked for every delivery consumed
+// directly from the queue (no exchange binding).
+func (s *Sink) HandlerFunc(handler SimpleHandlerFunc) {
+	s.handler = handler
+}
+
+func (s *Sink) queueArgs() amqp.Table {
+	if !s.lazy {
+		return nil
+	}
+	return amqp.Table{"x-queue-mode": "lazy"}
+}
+
+func (s *Sink) serve(ctx context.Context, conn *amqp.Connection) {
+	ch, err := conn.Channel()
+	if err != nil {
+		s.broker.logger.Println("failed to open channel:", err)
+		return
+	}
+	q, err := ch.QueueDeclare(s.queue, true, s.queue == "", false, false, s.queueArgs())
+	if err != nil {
+		s.broker.logger.Println("failed to declare queue:", err)
+		return
+	}
+
+	handler := s.handler
+	if s.exchange != nil {
+		if err := ch.ExchangeDeclare(s.exchange.name, s.exchange.kind, true, false, false, false, nil); err != nil {
+			s.broker.logger.Println("failed to declare exchange:", err)
+			return
+		}
+		keys := s.exchange.keys
+		if len(keys) == 0 {
+			keys = []string{""}
+		}
+		for _, key := range keys {
+			if err := ch.QueueBind(q.Name, key, s.exchange.name, false, nil); err != nil {
+				s.broker.logger.Println("failed to bind queue:", err)
+				return
+			}
+		}
+		handler = s.exchange.handler
+	}
+	if handler == nil {
+		return
+	}
+	if s.prefetch > 0 {
+		if err := ch.Qos(s.prefetch, 0, false); err != nil {
+			s.broker.logger.Println("failed to set qos:", err)
+			return
+		}
+	}
+
+	deliveries, err := ch.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		s.broker.logger.Println("failed to consume:", err)
+		return
+	}
+	s.broker.wg.Add(1)
+	go s.consume(ctx, handler, deliveries)
+}
+
+func (s *Sink) consume(ctx context.Context, handler SimpleHandler, deliveries <-chan amqp.Delivery) {
+	defer s.broker.wg.Done()
+	for {
+		select {
+		case msg, ok := <-deliveries:
+			if !ok {
+				return
+			}
+			// select picks at random among ready cases, so stop here rather
+			// than handle buffered deliveries after ctx is done; the unacked
+			// message is redelivered by the broker.
+			if ctx.Err() != nil {
+				return
+			}
+			if s.broker.metrics != nil {
+				s.broker.metrics.messagesReceived.Inc()
+			}
+			if len(s.verifyKeys) > 0 && !verifySignature(s.verifyKeys, msg) {
+				_ = msg.Nack(false, false)
+				if s.broker.metrics != nil {
+					s.broker.metrics.messagesNacked.Inc()
+				}
+				continue
+			}
+			start := time.Now()
+			handler.Handle(ctx, amqpDelivery{raw: msg})
+			if s.broker.metrics != nil {
+				s.broker.metrics.handlerLatency.Observe(time.Since(start).Seconds())
+			}
+			if !s.manualAck {
+				_ = msg.Ack(false)
+				if s.broker.metrics != nil {
+					s.broker.metrics.messagesAcked.Inc()
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
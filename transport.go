@@ -0,0 +1,29 @@
+package fluent
+
+import (
+	"context"
+	"time"
+)
+
+// Message is a transport-agnostic outbound message, used by Transport.Publish.
+type Message struct {
+	Headers       map[string]interface{}
+	ContentType   string
+	CorrelationId string
+	ReplyTo       string
+	MessageId     string
+	Timestamp     time.Time
+	Body          []byte
+}
+
+// Transport lets the recv CLI and other callers swap the messaging backend
+// (AMQP, NATS, ...) without rewriting handler code. NATSTransport is the
+// NATS implementation; the AMQP side is still driven directly through
+// Sink/Exchange (see BrokerBuilder.Publish for the one piece it shares).
+type Transport interface {
+	// Subscribe consumes subject until ctx is done, invoking handler for
+	// every delivery.
+	Subscribe(ctx context.Context, subject string, handler func(Delivery)) error
+	// Publish sends msg on subject.
+	Publish(ctx context.Context, subject string, msg Message) error
+}